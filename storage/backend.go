@@ -0,0 +1,25 @@
+// Package storage provides a generic interface to interact with cache storage backends.
+package storage
+
+import (
+	"context"
+	"io"
+)
+
+// Backend implements operations for a cache storage backend.
+type Backend interface {
+	// Get writes downloaded content to the given writer.
+	Get(ctx context.Context, p string, w io.Writer) error
+	// Put uploads contents of the given reader.
+	Put(ctx context.Context, p string, r io.Reader) error
+	// Exists checks if object already exists.
+	Exists(ctx context.Context, p string) (bool, error)
+}
+
+// Querier is implemented by backends that can evaluate a query against an
+// object server-side and stream back only the matching records, instead of
+// downloading the whole object. Callers should use a type assertion against
+// a Backend to check for support before calling Query.
+type Querier interface {
+	Query(ctx context.Context, key, expr string, w io.Writer) error
+}
@@ -0,0 +1,41 @@
+package s3
+
+import "time"
+
+// Config is a structure used to configure the S3 Backend.
+type Config struct {
+	Bucket   string
+	Region   string
+	Endpoint string
+
+	Key    string
+	Secret string
+
+	AssumeRoleARN        string // ARN to assume via STS before talking to S3.
+	WebIdentityTokenFile string // Path to a Kubernetes ServiceAccount token, for IRSA on EKS.
+	UseInstanceProfile   bool   // Add the EC2 instance metadata (instance profile) credential provider to the chain.
+	Profile              string // Shared config/credentials profile to use, if any.
+
+	ACL string
+
+	SSEAlgorithm            string            // "AES256", "aws:kms" or "aws:kms:dsse". Ignored when SSECustomerKey is set; left empty, no server-side encryption is requested.
+	SSEKMSKeyID             string            // CMK to use when SSEAlgorithm is "aws:kms" or "aws:kms:dsse".
+	SSEKMSEncryptionContext map[string]string // Authenticated encryption context, sent to S3 base64-encoded.
+
+	SSECustomerKey    string // Raw 256-bit customer-provided key (SSE-C), not base64-encoded.
+	SSECustomerKeyMD5 string // Base64-encoded MD5 digest of SSECustomerKey.
+
+	PathStyle bool // Use path style instead of domain style.
+
+	// TTL is how long the uploaded object should be retained for.
+	TTL string
+
+	PartSize          int64  // Size (in bytes) of each part uploaded to S3. The s3manager default (5MB) is used when zero.
+	Concurrency       int    // Number of parts uploaded in parallel. The s3manager default (5) is used when zero.
+	LeavePartsOnError bool   // Disable aborting a failed multipart upload, so its parts can be inspected or resumed.
+	ChecksumAlgorithm string // e.g. "CRC32C" or "SHA256". Left unset, the default flexible checksum behaviour applies.
+
+	MaxRetries     int           // Times a request is retried after a retryable error. The aws-sdk-go default (3) is used when zero.
+	RetryBaseDelay time.Duration // Delay before the first retry; later retries back off exponentially from it, with jitter.
+	RetryMaxDelay  time.Duration // Caps the exponential backoff delay between retries.
+}
@@ -0,0 +1,49 @@
+package s3
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/go-kit/kit/log"
+
+	"github.com/meltwater/drone-cache/test"
+)
+
+func TestBuildCredentialsStaticOnly(t *testing.T) {
+	creds, err := buildCredentials(Config{Region: testRegion, Key: "key", Secret: "secret"}, log.NewNopLogger())
+	test.Ok(t, err)
+
+	value, err := creds.Get()
+	test.Ok(t, err)
+	test.Equals(t, "key", value.AccessKeyID)
+	test.Equals(t, "secret", value.SecretAccessKey)
+}
+
+func TestBuildCredentialsNoneConfiguredFallsBackToAnonymous(t *testing.T) {
+	creds, err := buildCredentials(Config{Region: testRegion}, log.NewNopLogger())
+	test.Ok(t, err)
+
+	if creds != credentials.AnonymousCredentials {
+		t.Fatalf("expected AnonymousCredentials, got %#v", creds)
+	}
+}
+
+// TestBuildCredentialsProfileFallsBackToStatic exercises the chain ordering: the shared-profile
+// provider is tried before the static one, but a missing profile file falls through to the
+// statically configured keys rather than failing outright.
+func TestBuildCredentialsProfileFallsBackToStatic(t *testing.T) {
+	creds, err := buildCredentials(Config{
+		Region:  testRegion,
+		Profile: "does-not-exist",
+		Key:     "key",
+		Secret:  "secret",
+	}, log.NewNopLogger())
+	test.Ok(t, err)
+
+	value, err := creds.Get()
+	test.Ok(t, err)
+	test.Equals(t, "key", value.AccessKeyID)
+	test.Equals(t, "secret", value.SecretAccessKey)
+}
+
+const testRegion = "eu-west-1"
@@ -0,0 +1,55 @@
+package s3
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/go-kit/kit/log"
+
+	"github.com/meltwater/drone-cache/test"
+)
+
+func TestRetryerShouldRetry(t *testing.T) {
+	r := retryer{DefaultRetryer: client.DefaultRetryer{NumMaxRetries: 3}, logger: log.NewNopLogger()}
+
+	for _, code := range []string{"SlowDown", "RequestTimeout", "InternalError"} {
+		req := &request.Request{Error: awserr.New(code, code, nil)}
+		test.Equals(t, true, r.ShouldRetry(req))
+	}
+
+	canceled := &request.Request{Error: awserr.New(request.CanceledErrorCode, "canceled", nil)}
+	test.Equals(t, false, r.ShouldRetry(canceled))
+
+	serviceUnavailable := &request.Request{HTTPResponse: &http.Response{StatusCode: http.StatusServiceUnavailable}}
+	test.Equals(t, true, r.ShouldRetry(serviceUnavailable))
+
+	notRetryable := &request.Request{Error: awserr.New("ValidationError", "bad input", nil)}
+	test.Equals(t, false, r.ShouldRetry(notRetryable))
+}
+
+func TestRetryerRetryRulesHonorsThrottleDelay(t *testing.T) {
+	r := retryer{
+		DefaultRetryer: client.DefaultRetryer{
+			NumMaxRetries:    3,
+			MinRetryDelay:    time.Millisecond,
+			MaxRetryDelay:    2 * time.Millisecond,
+			MinThrottleDelay: time.Second,
+			MaxThrottleDelay: 2 * time.Second,
+		},
+		logger: log.NewNopLogger(),
+	}
+
+	req := &request.Request{
+		Error:        awserr.New("SlowDown", "slow down", nil),
+		HTTPResponse: &http.Response{StatusCode: http.StatusServiceUnavailable},
+		Operation:    &request.Operation{Name: "PutObject"},
+	}
+
+	if delay := r.RetryRules(req); delay < r.MinThrottleDelay {
+		t.Fatalf("expected throttle delay >= %s, got %s", r.MinThrottleDelay, delay)
+	}
+}
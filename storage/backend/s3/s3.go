@@ -1,33 +1,60 @@
 package s3
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 
 	"github.com/meltwater/drone-cache/internal"
+	"github.com/meltwater/drone-cache/storage"
 )
 
+// Compile time check that Backend implements storage.Querier.
+var _ storage.Querier = (*Backend)(nil)
+
 // Backend implements storage.Backend for AWs S3.
 type Backend struct {
 	logger log.Logger
 
-	bucket     string
-	acl        string
-	encryption string
-	client     *s3.S3
-	expiresAt  time.Time
+	bucket    string
+	acl       string
+	client    *s3.S3
+	ttl       string
+	expiresAt time.Time
+
+	sseAlgorithm            string
+	sseKMSKeyID             string
+	sseKMSEncryptionContext string
+	sseCustomerKey          string
+	sseCustomerKeyMD5       string
+
+	partSize          int64
+	concurrency       int
+	leavePartsOnError bool
+	checksumAlgorithm string
 }
 
 // New creates an S3 backend.
@@ -39,13 +66,32 @@ func New(l log.Logger, c Config, debug bool) (*Backend, error) {
 		Endpoint:         &c.Endpoint,
 		DisableSSL:       aws.Bool(!strings.HasPrefix(c.Endpoint, "https://")),
 		S3ForcePathStyle: aws.Bool(c.PathStyle),
-		Credentials:      credentials.AnonymousCredentials,
 	}
 
-	if c.Key != "" && c.Secret != "" {
-		conf.Credentials = credentials.NewStaticCredentials(c.Key, c.Secret, "")
-	} else {
-		level.Warn(l).Log("msg", "aws key and/or Secret not provided (falling back to anonymous credentials)")
+	creds, err := buildCredentials(c, l)
+	if err != nil {
+		return nil, fmt.Errorf("build aws credentials, %w", err)
+	}
+
+	conf.Credentials = creds
+
+	if c.MaxRetries > 0 || c.RetryBaseDelay > 0 || c.RetryMaxDelay > 0 {
+		maxRetries := c.MaxRetries
+		if maxRetries == 0 {
+			maxRetries = client.DefaultRetryerMaxNumRetries
+		}
+
+		conf.MaxRetries = aws.Int(maxRetries)
+		conf.Retryer = retryer{
+			DefaultRetryer: client.DefaultRetryer{
+				NumMaxRetries:    maxRetries,
+				MinRetryDelay:    c.RetryBaseDelay,
+				MaxRetryDelay:    c.RetryMaxDelay,
+				MinThrottleDelay: c.RetryBaseDelay,
+				MaxThrottleDelay: c.RetryMaxDelay,
+			},
+			logger: l,
+		}
 	}
 
 	level.Debug(l).Log("msg", "s3 backend", "config", fmt.Sprintf("%#v", c))
@@ -64,16 +110,128 @@ func New(l log.Logger, c Config, debug bool) (*Backend, error) {
 
 	client := s3.New(session.Must(session.NewSessionWithOptions(session.Options{})), conf)
 
+	var sseKMSEncryptionContext string
+
+	if len(c.SSEKMSEncryptionContext) > 0 {
+		b, err := json.Marshal(c.SSEKMSEncryptionContext)
+		if err != nil {
+			return nil, fmt.Errorf("encode the sse-kms encryption context, %w", err)
+		}
+
+		sseKMSEncryptionContext = base64.StdEncoding.EncodeToString(b)
+	}
+
 	return &Backend{
-		logger:     l,
-		bucket:     c.Bucket,
-		acl:        c.ACL,
-		encryption: c.Encryption,
-		client:     client,
-		expiresAt:  expiresAt,
+		logger:    l,
+		bucket:    c.Bucket,
+		acl:       c.ACL,
+		client:    client,
+		ttl:       c.TTL,
+		expiresAt: expiresAt,
+
+		sseAlgorithm:            c.SSEAlgorithm,
+		sseKMSKeyID:             c.SSEKMSKeyID,
+		sseKMSEncryptionContext: sseKMSEncryptionContext,
+		sseCustomerKey:          c.SSECustomerKey,
+		sseCustomerKeyMD5:       c.SSECustomerKeyMD5,
+
+		partSize:          c.PartSize,
+		concurrency:       c.Concurrency,
+		leavePartsOnError: c.LeavePartsOnError,
+		checksumAlgorithm: c.ChecksumAlgorithm,
 	}, nil
 }
 
+// retryer classifies S3-specific transient errors as retryable and logs each retry attempt.
+type retryer struct {
+	client.DefaultRetryer
+
+	logger log.Logger
+}
+
+// ShouldRetry reports whether req failed with a retryable error.
+func (r retryer) ShouldRetry(req *request.Request) bool {
+	if awsErr, ok := req.Error.(awserr.Error); ok {
+		switch awsErr.Code() {
+		case request.CanceledErrorCode:
+			return false
+		case "RequestTimeout", "SlowDown", "InternalError":
+			return true
+		}
+	}
+
+	if req.HTTPResponse != nil && req.HTTPResponse.StatusCode == http.StatusServiceUnavailable {
+		return true
+	}
+
+	return r.DefaultRetryer.ShouldRetry(req)
+}
+
+// RetryRules takes the delay from the embedded default retryer, then logs the retry attempt with it.
+func (r retryer) RetryRules(req *request.Request) time.Duration {
+	delay := r.DefaultRetryer.RetryRules(req)
+
+	level.Debug(r.logger).Log("msg", "retrying s3 request", "operation", req.Operation.Name, "attempt", req.RetryCount+1, "delay", delay, "err", req.Error)
+
+	return delay
+}
+
+// buildCredentials assembles a credential provider chain from the configured sources, falling
+// back to anonymous credentials when nothing else is configured.
+func buildCredentials(c Config, l log.Logger) (*credentials.Credentials, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(c.Region)})
+	if err != nil {
+		return nil, fmt.Errorf("create aws session, %w", err)
+	}
+
+	var providers []credentials.Provider
+
+	if c.AssumeRoleARN != "" {
+		if c.WebIdentityTokenFile != "" {
+			providers = append(providers, stscreds.NewWebIdentityRoleProvider(sts.New(sess), c.AssumeRoleARN, "drone-cache", c.WebIdentityTokenFile))
+		} else {
+			providers = append(providers, &stscreds.AssumeRoleProvider{
+				Client:  sts.New(assumeRoleSession(c, sess)),
+				RoleARN: c.AssumeRoleARN,
+			})
+		}
+	}
+
+	if c.UseInstanceProfile {
+		providers = append(providers, &ec2rolecreds.EC2RoleProvider{Client: ec2metadata.New(sess)})
+	}
+
+	if c.Profile != "" {
+		providers = append(providers, &credentials.SharedCredentialsProvider{Profile: c.Profile})
+	}
+
+	if c.Key != "" && c.Secret != "" {
+		providers = append(providers, &credentials.StaticProvider{
+			Value: credentials.Value{AccessKeyID: c.Key, SecretAccessKey: c.Secret},
+		})
+	}
+
+	if len(providers) == 0 {
+		level.Warn(l).Log("msg", "no aws credentials configured (falling back to anonymous credentials)")
+		return credentials.AnonymousCredentials, nil
+	}
+
+	return credentials.NewChainCredentials(providers), nil
+}
+
+// assumeRoleSession returns fallback carrying c's static or shared-profile credentials, if any,
+// so the AssumeRole call authenticates with them instead of the ambient credential chain.
+func assumeRoleSession(c Config, fallback *session.Session) *session.Session {
+	switch {
+	case c.Key != "" && c.Secret != "":
+		return fallback.Copy(&aws.Config{Credentials: credentials.NewStaticCredentials(c.Key, c.Secret, "")})
+	case c.Profile != "":
+		return fallback.Copy(&aws.Config{Credentials: credentials.NewSharedCredentials("", c.Profile)})
+	default:
+		return fallback
+	}
+}
+
 // Get writes downloaded content to the given writer.
 func (b *Backend) Get(ctx context.Context, p string, w io.Writer) error {
 	in := &s3.GetObjectInput{
@@ -81,6 +239,12 @@ func (b *Backend) Get(ctx context.Context, p string, w io.Writer) error {
 		Key:    aws.String(p),
 	}
 
+	if b.sseCustomerKey != "" {
+		in.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		in.SSECustomerKey = aws.String(b.sseCustomerKey)
+		in.SSECustomerKeyMD5 = aws.String(b.sseCustomerKeyMD5)
+	}
+
 	errCh := make(chan error)
 
 	go func() {
@@ -108,11 +272,95 @@ func (b *Backend) Get(ctx context.Context, p string, w io.Writer) error {
 	}
 }
 
+// Query runs a SQL-like expression against the JSON Lines object stored at p (such as a manifest
+// uploaded by PutManifest, whose key is the archive's key plus manifestSuffix) using S3 Select,
+// and streams the matching records to w. The key is used as given; no suffix is appended.
+func (b *Backend) Query(ctx context.Context, p, expr string, w io.Writer) error {
+	in := &s3.SelectObjectContentInput{
+		Bucket:         aws.String(b.bucket),
+		Key:            aws.String(p),
+		Expression:     aws.String(expr),
+		ExpressionType: aws.String(s3.ExpressionTypeSql),
+		InputSerialization: &s3.InputSerialization{
+			JSON: &s3.JSONInput{Type: aws.String(s3.JSONTypeLines)},
+		},
+		OutputSerialization: &s3.OutputSerialization{
+			JSON: &s3.JSONOutput{},
+		},
+	}
+
+	if b.sseCustomerKey != "" {
+		in.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		in.SSECustomerKey = aws.String(b.sseCustomerKey)
+		in.SSECustomerKeyMD5 = aws.String(b.sseCustomerKeyMD5)
+	}
+
+	out, err := b.client.SelectObjectContentWithContext(ctx, in)
+	if err != nil {
+		return fmt.Errorf("select the object, %w", err)
+	}
+
+	defer internal.CloseWithErrLogf(b.logger, out.EventStream, "select event stream, close defer")
+
+	for event := range out.EventStream.Events() {
+		record, ok := event.(*s3.RecordsEvent)
+		if !ok {
+			continue
+		}
+
+		if _, err := w.Write(record.Payload); err != nil {
+			return fmt.Errorf("write the select records, %w", err)
+		}
+	}
+
+	if err := out.EventStream.Err(); err != nil {
+		return fmt.Errorf("read the select event stream, %w", err)
+	}
+
+	return nil
+}
+
+// ManifestEntry describes a single file packed into a cache archive, as recorded in the
+// JSON Lines manifest uploaded alongside it.
+type ManifestEntry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// manifestSuffix is appended to an archive's key to get the key of its manifest object.
+const manifestSuffix = ".manifest.jsonl"
+
+// PutManifest uploads entries as a JSON Lines manifest alongside the archive stored at p, so that
+// Query can run S3 Select expressions against it without downloading the archive itself.
+func (b *Backend) PutManifest(ctx context.Context, p string, entries []ManifestEntry) error {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return fmt.Errorf("encode the manifest entry, %w", err)
+		}
+	}
+
+	return b.Put(ctx, p+manifestSuffix, &buf)
+}
+
 // Put uploads contents of the given reader.
 func (b *Backend) Put(ctx context.Context, p string, r io.Reader) error {
 	var (
-		uploader = s3manager.NewUploaderWithClient(b.client)
-		in       = &s3manager.UploadInput{
+		uploader = s3manager.NewUploaderWithClient(b.client, func(u *s3manager.Uploader) {
+			if b.partSize > 0 {
+				u.PartSize = b.partSize
+			}
+
+			if b.concurrency > 0 {
+				u.Concurrency = b.concurrency
+			}
+
+			u.LeavePartsOnError = b.leavePartsOnError
+		})
+		in = &s3manager.UploadInput{
 			Bucket: aws.String(b.bucket),
 			Key:    aws.String(p),
 			ACL:    aws.String(b.acl),
@@ -120,37 +368,99 @@ func (b *Backend) Put(ctx context.Context, p string, r io.Reader) error {
 		}
 	)
 
-	if b.encryption != "" {
-		in.ServerSideEncryption = aws.String(b.encryption)
+	if b.sseCustomerKey != "" {
+		in.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		in.SSECustomerKey = aws.String(b.sseCustomerKey)
+		in.SSECustomerKeyMD5 = aws.String(b.sseCustomerKeyMD5)
+	} else if b.sseAlgorithm != "" {
+		in.ServerSideEncryption = aws.String(b.sseAlgorithm)
+
+		if b.sseKMSKeyID != "" {
+			in.SSEKMSKeyId = aws.String(b.sseKMSKeyID)
+		}
+
+		if b.sseKMSEncryptionContext != "" {
+			in.SSEKMSEncryptionContext = aws.String(b.sseKMSEncryptionContext)
+		}
+	}
+
+	if b.checksumAlgorithm != "" {
+		in.ChecksumAlgorithm = aws.String(b.checksumAlgorithm)
+	}
+
+	// Tag the object with its TTL instead of rewriting the bucket lifecycle policy; see
+	// EnsureLifecyclePolicy.
+	if !b.expiresAt.IsZero() {
+		in.Expires = aws.Time(b.expiresAt)
+		in.Tagging = aws.String(url.Values{lifecycleTagKey: []string{b.ttl}}.Encode())
 	}
 
 	if _, err := uploader.UploadWithContext(ctx, in); err != nil {
 		return fmt.Errorf("put the object, %w", err)
 	}
 
-	// Check whether TTL flag is supplied. If so, add a lifecycle configuration to the bucket, matching the key
+	return nil
+}
 
-	lifecycleConfiguration := &s3.BucketLifecycleConfiguration{
-		Rules: []*s3.LifecycleRule{
-			&s3.LifecycleRule{
-				Filter: &s3.LifecycleRuleFilter{
-					Prefix: aws.String(p),
-				},
-				Expiration: &s3.LifecycleExpiration{
-					Date: &b.expiresAt,
-				},
-			},
-		},
+// lifecycleTagKey is the object tag Put stamps on TTL'd objects and EnsureLifecyclePolicy
+// expires objects on.
+const lifecycleTagKey = "drone-cache-ttl"
+
+// EnsureLifecyclePolicy merges a lifecycle rule expiring tagged objects into the bucket's
+// existing rules. Call it once (e.g. during setup), not on every Put. The read-modify-write is
+// not atomic, so concurrent callers against the same bucket can still race and drop each other's
+// rule; it only narrows the window from "every Put" to "once per process".
+func (b *Backend) EnsureLifecyclePolicy(ctx context.Context) error {
+	if b.ttl == "" {
+		return nil
 	}
 
-	putBucketLifecycleConfigurationInput := &s3.PutBucketLifecycleConfigurationInput{
-		Bucket:                 aws.String(b.bucket),
-		LifecycleConfiguration: lifecycleConfiguration,
+	duration, err := time.ParseDuration(b.ttl)
+	if err != nil {
+		return fmt.Errorf("parse the ttl, %w", err)
 	}
 
-	_, err := b.client.PutBucketLifecycleConfiguration(putBucketLifecycleConfigurationInput)
+	ruleID := lifecycleTagKey + "-" + b.ttl
+
+	var rules []*s3.LifecycleRule
+
+	out, err := b.client.GetBucketLifecycleConfigurationWithContext(ctx, &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(b.bucket),
+	})
 	if err != nil {
-		return fmt.Errorf("put the object, %w", err)
+		var awsErr awserr.Error
+		if !errors.As(err, &awsErr) || awsErr.Code() != "NoSuchLifecycleConfiguration" {
+			return fmt.Errorf("get the bucket lifecycle configuration, %w", err)
+		}
+	} else {
+		for _, rule := range out.Rules {
+			if rule.ID != nil && *rule.ID == ruleID {
+				continue
+			}
+
+			rules = append(rules, rule)
+		}
+	}
+
+	rules = append(rules, &s3.LifecycleRule{
+		ID:     aws.String(ruleID),
+		Status: aws.String(s3.ExpirationStatusEnabled),
+		Filter: &s3.LifecycleRuleFilter{
+			Tag: &s3.Tag{
+				Key:   aws.String(lifecycleTagKey),
+				Value: aws.String(b.ttl),
+			},
+		},
+		Expiration: &s3.LifecycleExpiration{
+			Days: aws.Int64((int64(duration.Hours()) + 23) / 24),
+		},
+	})
+
+	if _, err := b.client.PutBucketLifecycleConfigurationWithContext(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(b.bucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{Rules: rules},
+	}); err != nil {
+		return fmt.Errorf("put the bucket lifecycle configuration, %w", err)
 	}
 
 	return nil
@@ -163,6 +473,12 @@ func (b *Backend) Exists(ctx context.Context, p string) (bool, error) {
 		Key:    aws.String(p),
 	}
 
+	if b.sseCustomerKey != "" {
+		in.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		in.SSECustomerKey = aws.String(b.sseCustomerKey)
+		in.SSECustomerKeyMD5 = aws.String(b.sseCustomerKeyMD5)
+	}
+
 	out, err := b.client.HeadObjectWithContext(ctx, in)
 	if err != nil {
 		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == s3.ErrCodeNoSuchKey || awsErr.Code() == "NotFound" {